@@ -1,28 +1,78 @@
 package queue
 
 import (
+	"context"
+	"errors"
 	"sync"
+	"time"
 
 	"github.com/garyburd/redigo/redis"
 )
 
+// ErrNotDelayed is returned by PushAt/PushAfter when the queue's current
+// Processor does not implement DelayedPusher.
+var ErrNotDelayed = errors.New("queue: processor does not support delayed pushes")
+
+// DelayedPusher is implemented by Processors that can stage a payload for
+// delivery at a future time rather than immediately, such as the one built
+// by NewDelayedProcessor.
+type DelayedPusher interface {
+	PushAt(cnx redis.Conn, src string, payload []byte, at time.Time) error
+}
+
+// ErrNotReliable is returned by Ack/Nack when the queue's current Processor
+// does not implement Acker.
+var ErrNotReliable = errors.New("queue: processor does not support ack/nack")
+
+// Acker is implemented by Processors that lease payloads out rather than
+// removing them outright, such as the one built by NewReliableProcessor. Ack
+// marks a leased payload as handled; Nack marks it as failed and makes it
+// immediately available for redelivery.
+type Acker interface {
+	Ack(cnx redis.Conn, src string, payload []byte) error
+	Nack(cnx redis.Conn, src string, payload []byte) error
+}
+
+// ErrNotPrioritized is returned by PushWithPriority when the queue's
+// current Processor does not implement PriorityPusher.
+var ErrNotPrioritized = errors.New("queue: processor does not support prioritized pushes")
+
+// PriorityPusher is implemented by Processors that can associate an
+// explicit priority with a pushed payload, such as the one built by
+// NewPriorityProcessor.
+type PriorityPusher interface {
+	PushWithPriority(cnx redis.Conn, src string, payload []byte, priority int) error
+}
+
 // BaseQueue provides a basic implementation of the Queue interface. Its basic
 // methodology is to preform updates using a Processor interface which in and of
 // itself defines how updates can be handled.
 type BaseQueue struct {
-	pool   *redis.Pool
-	source string
+	factory PoolFactory
+	source  string
 
 	pmu       sync.RWMutex
 	processor Processor
+
+	cmu   sync.RWMutex
+	codec Codec
 }
 
 var _ Queue = new(BaseQueue)
 
 func NewBaseQueue(pool *redis.Pool, source string) *BaseQueue {
+	return NewBaseQueueFromFactory(&staticPool{pool: pool}, source)
+}
+
+// NewBaseQueueFromFactory builds a BaseQueue that obtains its Redis
+// connections through factory rather than a fixed *redis.Pool. This is the
+// entry point used by topology-aware constructors such as NewSentinelQueue,
+// whose factory may swap the pool it returns out from under the queue on
+// failover.
+func NewBaseQueueFromFactory(factory PoolFactory, source string) *BaseQueue {
 	return &BaseQueue{
-		pool:   pool,
-		source: source,
+		factory: factory,
+		source:  source,
 	}
 }
 
@@ -39,18 +89,150 @@ func (q *BaseQueue) Source() string {
 // If an error occurs during Pushing, it will be returned, and it can be assumed
 // that the payload is not in Redis.
 func (q *BaseQueue) Push(payload []byte) (err error) {
-	cnx := q.pool.Get()
+	cnx := q.factory.Pool().Get()
 	defer cnx.Close()
 
 	return q.Processor().Push(cnx, q.Source(), payload)
 }
 
-// Source implements the Source method on the Queue interface.
-func (q *BaseQueue) Pull() (payload []byte, err error) {
-	cnx := q.pool.Get()
+// PushAt schedules payload for delivery at the given time instead of
+// pushing it immediately, by delegating into the current Processor's
+// DelayedPusher implementation. It returns ErrNotDelayed if the current
+// Processor doesn't support delayed pushes.
+func (q *BaseQueue) PushAt(payload []byte, at time.Time) error {
+	pusher, ok := q.Processor().(DelayedPusher)
+	if !ok {
+		return ErrNotDelayed
+	}
+
+	cnx := q.factory.Pool().Get()
+	defer cnx.Close()
+
+	return pusher.PushAt(cnx, q.Source(), payload, at)
+}
+
+// PushAfter schedules payload for delivery after the given duration has
+// elapsed. It is a convenience wrapper around PushAt.
+func (q *BaseQueue) PushAfter(payload []byte, after time.Duration) error {
+	return q.PushAt(payload, time.Now().Add(after))
+}
+
+// PushWithPriority pushes payload into a priority tier instead of the
+// default one, by delegating into the current Processor's PriorityPusher
+// implementation. Higher values are serviced first. It returns
+// ErrNotPrioritized if the current Processor doesn't support priorities.
+func (q *BaseQueue) PushWithPriority(payload []byte, priority int) error {
+	pusher, ok := q.Processor().(PriorityPusher)
+	if !ok {
+		return ErrNotPrioritized
+	}
+
+	cnx := q.factory.Pool().Get()
 	defer cnx.Close()
 
-	return q.Processor().Pull(cnx, q.Source())
+	return pusher.PushWithPriority(cnx, q.Source(), payload, priority)
+}
+
+// Pull implements the Pull method on the Queue interface by delegating into
+// the Processor's `func Pull`. timeout controls how long it waits for a
+// payload to become available: zero blocks forever, a positive duration
+// blocks for at most that long, and a negative duration returns immediately
+// if nothing is queued.
+func (q *BaseQueue) Pull(timeout time.Duration) (payload []byte, err error) {
+	cnx := q.factory.Pool().Get()
+	defer cnx.Close()
+
+	return q.Processor().Pull(cnx, q.Source(), timeout)
+}
+
+// PullContext behaves like Pull, but additionally aborts an in-progress
+// blocking wait by closing the underlying Redis connection if ctx is
+// canceled before a payload becomes available. This makes it safe to wait
+// forever (timeout 0) as long as ctx is eventually canceled.
+func (q *BaseQueue) PullContext(ctx context.Context, timeout time.Duration) (payload []byte, err error) {
+	cnx := q.factory.Pool().Get()
+	defer cnx.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			cnx.Close()
+		case <-done:
+		}
+	}()
+
+	payload, err = q.Processor().Pull(cnx, q.Source(), timeout)
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	return payload, err
+}
+
+// PullTo behaves like Pull, but moves the payload onto dest instead of
+// discarding it, by delegating into the Processor's `func PullTo`. Whether
+// the move is atomic depends on the Processor: PRIORITY's PullTo is a Pull
+// followed by a separate RPUSH.
+func (q *BaseQueue) PullTo(dest string, timeout time.Duration) (payload []byte, err error) {
+	cnx := q.factory.Pool().Get()
+	defer cnx.Close()
+
+	return q.Processor().PullTo(cnx, q.Source(), dest, timeout)
+}
+
+// PullToContext behaves like PullTo, but additionally aborts an
+// in-progress blocking wait by closing the underlying Redis connection if
+// ctx is canceled before a payload becomes available.
+func (q *BaseQueue) PullToContext(ctx context.Context, dest string, timeout time.Duration) (payload []byte, err error) {
+	cnx := q.factory.Pool().Get()
+	defer cnx.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			cnx.Close()
+		case <-done:
+		}
+	}()
+
+	payload, err = q.Processor().PullTo(cnx, q.Source(), dest, timeout)
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	return payload, err
+}
+
+// Concat moves the next payload out of Source() onto dest, by delegating
+// into the Processor's `func Concat`, without returning the payload itself.
+func (q *BaseQueue) Concat(dest string) error {
+	cnx := q.factory.Pool().Get()
+	defer cnx.Close()
+
+	return q.Processor().Concat(cnx, q.Source(), dest)
+}
+
+// Peek returns up to n payloads currently sitting in Source(), without
+// removing them, via LRANGE. The payload that the next Pull would return
+// is last in the returned slice. n <= 0 returns no payloads without
+// querying Redis, rather than LRANGE's own "0 means the whole list"
+// behavior for a literal 0. It is intended for observability - inspecting
+// what's pending - not as a substitute for Pull.
+func (q *BaseQueue) Peek(n int) ([][]byte, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+
+	cnx := q.factory.Pool().Get()
+	defer cnx.Close()
+
+	return redis.ByteSlices(cnx.Do("LRANGE", q.Source(), -n, -1))
 }
 
 // Source implements the Source method on the Queue interface. It functions by
@@ -68,6 +250,37 @@ func (q *BaseQueue) Processor() Processor {
 	return q.processor
 }
 
+// Ack marks payload, previously returned by Pull, as successfully handled
+// by delegating into the current Processor's Acker implementation. It
+// returns ErrNotReliable if the current Processor doesn't support Ack/Nack.
+func (q *BaseQueue) Ack(payload []byte) error {
+	acker, ok := q.Processor().(Acker)
+	if !ok {
+		return ErrNotReliable
+	}
+
+	cnx := q.factory.Pool().Get()
+	defer cnx.Close()
+
+	return acker.Ack(cnx, q.Source(), payload)
+}
+
+// Nack marks payload, previously returned by Pull, as failed, making it
+// immediately available for redelivery by delegating into the current
+// Processor's Acker implementation. It returns ErrNotReliable if the
+// current Processor doesn't support Ack/Nack.
+func (q *BaseQueue) Nack(payload []byte) error {
+	acker, ok := q.Processor().(Acker)
+	if !ok {
+		return ErrNotReliable
+	}
+
+	cnx := q.factory.Pool().Get()
+	defer cnx.Close()
+
+	return acker.Nack(cnx, q.Source(), payload)
+}
+
 // SetProcessor implements the SetProcessor method on the Queue interface. It
 // functions by requesting write-level access from the guarding mutex and
 // preforms the update atomically.
@@ -77,3 +290,70 @@ func (q *BaseQueue) SetProcessor(processor Processor) {
 
 	q.processor = processor
 }
+
+// Codec returns the queue's currently configured Codec. It functions by
+// requesting a read-level lock from the guarding mutex and returning that
+// value once obtained. If no codec is set, JSONCodec is returned.
+func (q *BaseQueue) Codec() Codec {
+	q.cmu.RLock()
+	defer q.cmu.RUnlock()
+
+	if q.codec == nil {
+		return JSONCodec
+	}
+
+	return q.codec
+}
+
+// SetCodec sets the Codec used by PushObject/PullObject. It functions by
+// requesting write-level access from the guarding mutex and preforms the
+// update atomically.
+func (q *BaseQueue) SetCodec(codec Codec) {
+	q.cmu.Lock()
+	defer q.cmu.Unlock()
+
+	q.codec = codec
+}
+
+// PushObject encodes v with the queue's Codec and pushes the result, for
+// callers that would otherwise hand-marshal every payload themselves.
+func (q *BaseQueue) PushObject(v interface{}) error {
+	payload, err := q.Codec().Encode(v)
+	if err != nil {
+		return err
+	}
+
+	return q.Push(payload)
+}
+
+// PushObjectAt encodes v with the queue's Codec and schedules the result
+// for delivery at the given time via PushAt, for callers combining
+// PushObject's convenience with a delayed Processor such as the one built
+// by NewDelayedProcessor.
+func (q *BaseQueue) PushObjectAt(v interface{}, at time.Time) error {
+	payload, err := q.Codec().Encode(v)
+	if err != nil {
+		return err
+	}
+
+	return q.PushAt(payload, at)
+}
+
+// PushObjectAfter schedules v for delivery after the given duration has
+// elapsed. It is a convenience wrapper around PushObjectAt.
+func (q *BaseQueue) PushObjectAfter(v interface{}, after time.Duration) error {
+	return q.PushObjectAt(v, time.Now().Add(after))
+}
+
+// PullObject pulls a payload with the same timeout semantics as Pull, and
+// decodes it into v with the queue's Codec. If nothing was available
+// within timeout, v is left untouched and a nil error is returned, matching
+// Pull's ErrNil-silencing behavior.
+func (q *BaseQueue) PullObject(timeout time.Duration, v interface{}) error {
+	payload, err := q.Pull(timeout)
+	if err != nil || payload == nil {
+		return err
+	}
+
+	return q.Codec().Decode(payload, v)
+}
@@ -0,0 +1,153 @@
+package queue
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+const clusterSlots = 16384
+
+// ErrCrossSlot is returned by a cluster-backed queue operation that would
+// need to touch two different Redis Cluster hash slots in a single atomic
+// command, such as PullTo or Concat between keys that don't share a hash
+// tag. Redis Cluster cannot execute such a command, so callers must route
+// the keys to the same slot (typically with a `{tag}` in each key) before
+// retrying.
+var ErrCrossSlot = errors.New("queue: source and destination hash to different cluster slots")
+
+// ClusterConfig describes a Redis Cluster to route queue operations
+// against.
+type ClusterConfig struct {
+	// Shards holds one pool per master node, ordered so that Shards[i] owns
+	// every slot s for which s*len(Shards)/clusterSlots == i (integer
+	// division).
+	// This is a simplification of full `CLUSTER SLOTS` discovery: callers
+	// are expected to keep Shards in the same order as their cluster's slot
+	// assignment.
+	Shards []*redis.Pool
+}
+
+// ClusterQueue is a Queue implementation that shards its keyspace across a
+// Redis Cluster by hashing `source` (honoring any `{hash tag}` it contains)
+// to a slot, and routing each command to the pool that owns that slot.
+type ClusterQueue struct {
+	cfg    ClusterConfig
+	source string
+
+	pmu       sync.RWMutex
+	processor Processor
+}
+
+var _ Queue = new(ClusterQueue)
+
+// NewClusterQueue builds a ClusterQueue that shards source across the pools
+// in cfg.Shards. It returns an error if cfg.Shards is empty, since poolFor
+// would otherwise panic with an index-out-of-range on the first Push/Pull.
+func NewClusterQueue(cfg ClusterConfig, source string) (*ClusterQueue, error) {
+	if len(cfg.Shards) == 0 {
+		return nil, errors.New("queue: ClusterConfig requires at least one shard")
+	}
+
+	return &ClusterQueue{cfg: cfg, source: source}, nil
+}
+
+// Source implements the Source method on the Queue interface.
+func (q *ClusterQueue) Source() string {
+	return q.source
+}
+
+// Processor implements the Processor method on the Queue interface. It
+// functions by requesting a read-level lock from the guarding mutex and
+// returning that value once obtained. If no processor is set, the default
+// FIFO implementation is returned.
+func (q *ClusterQueue) Processor() Processor {
+	q.pmu.RLock()
+	defer q.pmu.RUnlock()
+
+	if q.processor == nil {
+		return FIFO
+	}
+
+	return q.processor
+}
+
+// SetProcessor implements the SetProcessor method on the Queue interface. It
+// functions by requesting write-level access from the guarding mutex and
+// preforms the update atomically.
+func (q *ClusterQueue) SetProcessor(processor Processor) {
+	q.pmu.Lock()
+	defer q.pmu.Unlock()
+
+	q.processor = processor
+}
+
+// Push implements the Push method on the Queue interface, routing the
+// command to the shard owning q.Source().
+func (q *ClusterQueue) Push(payload []byte) error {
+	cnx := q.poolFor(q.source).Get()
+	defer cnx.Close()
+
+	return q.Processor().Push(cnx, q.source, payload)
+}
+
+// Pull implements the Pull method on the Queue interface, routing the
+// command to the shard owning q.Source().
+func (q *ClusterQueue) Pull(timeout time.Duration) ([]byte, error) {
+	cnx := q.poolFor(q.source).Get()
+	defer cnx.Close()
+
+	return q.Processor().Pull(cnx, q.source, timeout)
+}
+
+// PullTo pulls from q.Source() and pushes onto dest, refusing the operation
+// with ErrCrossSlot unless both keys hash to the same cluster slot, since
+// BRPOPLPUSH cannot span nodes.
+func (q *ClusterQueue) PullTo(dest string, timeout time.Duration) ([]byte, error) {
+	if clusterSlot(q.source) != clusterSlot(dest) {
+		return nil, ErrCrossSlot
+	}
+
+	cnx := q.poolFor(q.source).Get()
+	defer cnx.Close()
+
+	return q.Processor().PullTo(cnx, q.source, dest, timeout)
+}
+
+// Concat moves the head of q.Source() onto dest, refusing the operation
+// with ErrCrossSlot unless both keys hash to the same cluster slot.
+func (q *ClusterQueue) Concat(dest string) error {
+	if clusterSlot(q.source) != clusterSlot(dest) {
+		return ErrCrossSlot
+	}
+
+	cnx := q.poolFor(q.source).Get()
+	defer cnx.Close()
+
+	return q.Processor().Concat(cnx, q.source, dest)
+}
+
+// poolFor returns the pool owning the cluster slot that key hashes to.
+func (q *ClusterQueue) poolFor(key string) *redis.Pool {
+	shards := len(q.cfg.Shards)
+	slot := clusterSlot(key)
+
+	return q.cfg.Shards[slot*shards/clusterSlots]
+}
+
+// clusterSlot computes the Redis Cluster hash slot for key, honoring
+// `{hash tag}` substrings the way Redis Cluster clients do: if key contains
+// a `{...}`, only the portion inside the braces is hashed, so related keys
+// can be pinned to the same slot.
+func clusterSlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start != -1 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+
+	return int(crc16(key)) % clusterSlots
+}
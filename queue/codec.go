@@ -0,0 +1,68 @@
+package queue
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+
+	"gopkg.in/vmihailenco/msgpack.v2"
+)
+
+// Codec encodes and decodes Go values into the []byte payloads BaseQueue
+// deals in, letting PushObject/PullObject callers work with typed values
+// instead of hand-marshaling every payload.
+type Codec interface {
+	Encode(v interface{}) ([]byte, error)
+	Decode(data []byte, v interface{}) error
+}
+
+type jsonCodec struct{}
+
+// JSONCodec encodes payloads with encoding/json. It is BaseQueue's default
+// Codec.
+var JSONCodec Codec = jsonCodec{}
+
+func (jsonCodec) Encode(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Decode(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type gobCodec struct{}
+
+// GobCodec encodes payloads with encoding/gob. Unlike JSONCodec, it
+// requires the encoding and decoding ends to agree on concrete Go types
+// ahead of time, and any interface{}-typed field (TaskSignature.Args, for
+// instance) must have its concrete types registered with gob.Register
+// before encoding or decoding.
+var GobCodec Codec = gobCodec{}
+
+func (gobCodec) Encode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Decode(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type msgpackCodec struct{}
+
+// MsgpackCodec encodes payloads with MessagePack, giving a more compact
+// wire format than JSONCodec at the cost of not being human-readable.
+var MsgpackCodec Codec = msgpackCodec{}
+
+func (msgpackCodec) Encode(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgpackCodec) Decode(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
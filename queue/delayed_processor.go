@@ -0,0 +1,143 @@
+package queue
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// delayedDueScript atomically removes and returns, in ascending due-time
+// order, every member of the delayed ZSET whose score (a Unix-millisecond
+// timestamp) is not after `now`. It only touches the ZSET: migrating each
+// returned payload into dest is left to pollDue, since dest.Push (e.g.
+// FIFO's LPUSH vs LIFO's RPUSH) determines which end of the destination
+// structure a due payload actually belongs on.
+var delayedDueScript = redis.NewScript(1, `
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+if next(due) == nil then
+	return due
+end
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+return due
+`)
+
+// delayedKey returns the key of the sorted set staging src's delayed
+// payloads.
+func delayedKey(src string) string {
+	return src + ":delayed"
+}
+
+// delayedProcessor is a Processor that stages payloads in a Redis sorted
+// set scored by the Unix-millisecond timestamp at which they become due,
+// and migrates due entries into dest's list so Pull/PullTo/Concat callers
+// don't need to change. Plain Push bypasses the delay entirely and writes
+// straight through to dest, since an un-scheduled push has nothing to wait
+// on.
+type delayedProcessor struct {
+	dest Processor
+}
+
+// NewDelayedProcessor builds a delayed Processor that stages payloads ahead
+// of delivery and migrates them into dest (typically FIFO or LIFO) once
+// due. Use NewDelayedQueue to also start the background poller that
+// performs the migration.
+func NewDelayedProcessor(dest Processor) Processor {
+	return &delayedProcessor{dest: dest}
+}
+
+// DELAYED is a delayed/scheduled Processor that migrates due entries into
+// FIFO's list. Prefer NewDelayedQueue, which wires a Processor like this one
+// up with the poller that actually performs the migration.
+var DELAYED Processor = NewDelayedProcessor(FIFO)
+
+func (d *delayedProcessor) Push(cnx redis.Conn, src string, payload []byte) error {
+	return d.dest.Push(cnx, src, payload)
+}
+
+func (d *delayedProcessor) Pull(cnx redis.Conn, src string, timeout time.Duration) ([]byte, error) {
+	return d.dest.Pull(cnx, src, timeout)
+}
+
+func (d *delayedProcessor) PullTo(cnx redis.Conn, src, dest string, timeout time.Duration) ([]byte, error) {
+	return d.dest.PullTo(cnx, src, dest, timeout)
+}
+
+func (d *delayedProcessor) Concat(cnx redis.Conn, src, dest string) error {
+	return d.dest.Concat(cnx, src, dest)
+}
+
+// PushAt implements DelayedPusher. It stages payload in src's delayed
+// sorted set, scored by at's Unix-millisecond timestamp, rather than
+// delivering it immediately.
+func (d *delayedProcessor) PushAt(cnx redis.Conn, src string, payload []byte, at time.Time) error {
+	score := strconv.FormatInt(at.UnixNano()/int64(time.Millisecond), 10)
+
+	_, err := cnx.Do("ZADD", delayedKey(src), score, payload)
+	return err
+}
+
+// pollDue migrates every entry of src's delayed sorted set due at or before
+// now into dest, oldest-due first, through dest.Push so each one lands on
+// the same end of dest a caller's own Push would use. It returns the number
+// of entries migrated before any error calling dest.Push is encountered.
+func (d *delayedProcessor) pollDue(cnx redis.Conn, src string, now time.Time) (int, error) {
+	score := strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10)
+
+	due, err := redis.ByteSlices(delayedDueScript.Do(cnx, delayedKey(src), score))
+	if err != nil {
+		return 0, err
+	}
+
+	for i, payload := range due {
+		if err := d.dest.Push(cnx, src, payload); err != nil {
+			return i, err
+		}
+	}
+
+	return len(due), nil
+}
+
+// startPoller runs pollDue against q every interval until the process
+// exits. It is started automatically by NewDelayedQueue.
+func (d *delayedProcessor) startPoller(q *BaseQueue, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			cnx := q.factory.Pool().Get()
+			_, err := d.pollDue(cnx, q.Source(), time.Now())
+			cnx.Close()
+
+			if err != nil && err != redis.ErrNil {
+				continue
+			}
+		}
+	}()
+}
+
+// NewDelayedQueue builds a BaseQueue whose processor stages payloads pushed
+// via PushAt/PushAfter in a Redis sorted set, and starts a background
+// poller that migrates due entries into dest's list (typically FIFO or
+// LIFO) every pollInterval so ordinary Pull calls see them arrive on
+// schedule.
+func NewDelayedQueue(pool *redis.Pool, source string, dest Processor, pollInterval time.Duration) *BaseQueue {
+	return NewDelayedQueueFromFactory(&staticPool{pool: pool}, source, dest, pollInterval)
+}
+
+// NewDelayedQueueFromFactory behaves like NewDelayedQueue, but obtains its
+// Redis connections through factory rather than a fixed *redis.Pool, so a
+// delayed queue can be built on top of a topology-aware PoolFactory such as
+// the one backing NewSentinelQueue.
+func NewDelayedQueueFromFactory(factory PoolFactory, source string, dest Processor, pollInterval time.Duration) *BaseQueue {
+	proc := &delayedProcessor{dest: dest}
+
+	q := NewBaseQueueFromFactory(factory, source)
+	q.SetProcessor(proc)
+
+	proc.startPoller(q, pollInterval)
+
+	return q
+}
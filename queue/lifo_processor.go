@@ -1,12 +1,31 @@
 package queue
 
-import "github.com/garyburd/redigo/redis"
+import (
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
 
 type lifoProcessor struct{}
 
-// FIFO is a last in, first out implementation of the Processor interface.
+// LIFO is a last in, first out implementation of the Processor interface.
 var LIFO Processor = &lifoProcessor{}
 
+// blockSeconds converts a positive timeout into the integer second count
+// Redis's blocking commands (BRPOP, BRPOPLPUSH, BZPOPMAX) expect, rounding
+// up rather than truncating. Truncating a sub-second timeout (e.g. 500ms)
+// down to 0 would tell Redis to block forever instead of returning within
+// the caller's requested bound, so any positive remainder rounds up to at
+// least one second. Only meant to be called with a positive timeout.
+func blockSeconds(timeout time.Duration) int {
+	seconds := int(timeout / time.Second)
+	if timeout%time.Second != 0 {
+		seconds++
+	}
+
+	return seconds
+}
+
 // Push implements the `func Push` from `Processor`. It pushes the right-side
 // of the Redis structure using RPUSH, and returns any errors encountered while
 // runnning that command.
@@ -15,8 +34,12 @@ func (l *lifoProcessor) Push(cnx redis.Conn, src string, payload []byte) (err er
 	return
 }
 
-// Pull implements the `func Pull` from `Processor`. It pulls from the right-side
-// of the Redis structure in a blocking-fashion, using BRPOP.
+// Pull implements the `func Pull` from `Processor`. It pulls from the
+// right-side of the Redis structure, waiting according to timeout: zero
+// blocks forever (BRPOP with a 0 timeout), a positive duration blocks for
+// at most that many seconds, rounded up to the nearest whole second since
+// BRPOP's timeout is second-granularity, and a negative duration pulls
+// without blocking at all, using RPOP.
 //
 // If an redis.ErrNil is returned, it is silenced, and both fields are returend
 // as nil. If the err is not a redis.ErrNil, but is still non-nil itself, then
@@ -24,8 +47,17 @@ func (l *lifoProcessor) Push(cnx redis.Conn, src string, payload []byte) (err er
 //
 // If an item can successfully be removed from the keyspace, it is returned
 // without error.
-func (l *lifoProcessor) Pull(cnx redis.Conn, src string) ([]byte, error) {
-	slices, err := redis.ByteSlices(cnx.Do("BRPOP", src, 0))
+func (l *lifoProcessor) Pull(cnx redis.Conn, src string, timeout time.Duration) ([]byte, error) {
+	if timeout < 0 {
+		payload, err := redis.Bytes(cnx.Do("RPOP", src))
+		if err == redis.ErrNil {
+			return nil, nil
+		}
+
+		return payload, err
+	}
+
+	slices, err := redis.ByteSlices(cnx.Do("BRPOP", src, blockSeconds(timeout)))
 	if err == redis.ErrNil {
 		return nil, nil
 	}
@@ -37,11 +69,23 @@ func (l *lifoProcessor) Pull(cnx redis.Conn, src string) ([]byte, error) {
 	return slices[1], nil
 }
 
-// PullTo implements the `func PullTo` from the `Processor` interface. It pulls
-// from the right-side of the Redis source (src) structure, and pushes to the
-// left side of the Redis destination (dest) structure.
-func (l *lifoProcessor) PullTo(cnx redis.Conn, src, dest string) ([]byte, error) {
-	bytes, err := redis.Bytes(cnx.Do("BRPOPLPUSH"))
+// PullTo implements the `func PullTo` from the `Processor` interface. It
+// pulls from the right-side of the Redis source (src) structure, and
+// pushes to the left side of the Redis destination (dest) structure,
+// waiting according to timeout with the same semantics as Pull, including
+// rounding sub-second positive timeouts up to one second.
+func (l *lifoProcessor) PullTo(cnx redis.Conn, src, dest string, timeout time.Duration) ([]byte, error) {
+	var (
+		bytes []byte
+		err   error
+	)
+
+	if timeout < 0 {
+		bytes, err = redis.Bytes(cnx.Do("RPOPLPUSH", src, dest))
+	} else {
+		bytes, err = redis.Bytes(cnx.Do("BRPOPLPUSH", src, dest, blockSeconds(timeout)))
+	}
+
 	if err == redis.ErrNil {
 		return nil, nil
 	}
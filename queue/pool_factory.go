@@ -0,0 +1,28 @@
+package queue
+
+import "github.com/garyburd/redigo/redis"
+
+// PoolFactory abstracts how a BaseQueue obtains the *redis.Pool it issues
+// commands against. The default used by NewBaseQueue simply returns a fixed
+// pool, but topology-aware constructors such as NewSentinelQueue supply an
+// implementation that rebuilds the pool in the background as the underlying
+// Redis deployment changes, so calls to Push/Pull/PullTo made after the
+// rebuild pick up the new pool. A call that already checked out a
+// connection from the old pool is not retried; it fails against whatever
+// that connection is still pointed at.
+type PoolFactory interface {
+	// Pool returns the pool that should currently be used to obtain a
+	// connection. Implementations that track topology changes may return a
+	// different *redis.Pool across calls.
+	Pool() *redis.Pool
+}
+
+// staticPool is the PoolFactory backing a BaseQueue constructed directly
+// from a *redis.Pool via NewBaseQueue; it always returns the same pool.
+type staticPool struct {
+	pool *redis.Pool
+}
+
+func (s *staticPool) Pool() *redis.Pool {
+	return s.pool
+}
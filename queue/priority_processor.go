@@ -0,0 +1,140 @@
+package queue
+
+import (
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// priorityProcessor is a Processor that stores payloads in a Redis sorted
+// set scored by priority, and pulls the highest-priority entry first via
+// ZPOPMAX/BZPOPMAX. Plain Push stores payloads at priority 0; callers that
+// want to route to a particular tier should call BaseQueue.PushWithPriority
+// instead.
+//
+// PullTo and Concat have no atomic ZSET/list equivalent, so they're
+// implemented as a Pull followed by a plain RPUSH onto dest; a crash
+// between the two drops the payload, the same way a crash right after a
+// plain BRPOP-based Pull would.
+type priorityProcessor struct{}
+
+// PRIORITY is a priority-queue Processor backed by a Redis sorted set. Use
+// SetProcessor(PRIORITY) on a BaseQueue together with PushWithPriority to
+// route payloads into priority tiers within a single source key.
+var PRIORITY Processor = &priorityProcessor{}
+
+// seqKey returns the key of the counter priorityPushScript uses to break
+// ties between payloads pushed at the same priority.
+func seqKey(src string) string {
+	return src + ":seq"
+}
+
+// priorityPushScript atomically assigns payload a score of priority plus a
+// strictly decreasing fraction of a per-src sequence counter, and ZADDs it
+// into src. Redis itself breaks a ZSET tie at equal scores lexicographically
+// by member bytes, which has nothing to do with push order, so encoding the
+// sequence into the score is what actually makes same-priority pushes come
+// out FIFO relative to each other.
+var priorityPushScript = redis.NewScript(2, `
+local seq = redis.call('INCR', KEYS[2])
+local score = tonumber(ARGV[1]) + 1 / (2 + seq)
+redis.call('ZADD', KEYS[1], score, ARGV[2])
+return seq
+`)
+
+func (p *priorityProcessor) Push(cnx redis.Conn, src string, payload []byte) error {
+	return p.PushWithPriority(cnx, src, payload, 0)
+}
+
+// PushWithPriority implements PriorityPusher. It adds payload to src's
+// sorted set scored by priority, with ties between payloads at the same
+// priority broken by a monotonic sequence counter so they're serviced FIFO
+// relative to each other.
+func (p *priorityProcessor) PushWithPriority(cnx redis.Conn, src string, payload []byte, priority int) error {
+	_, err := priorityPushScript.Do(cnx, src, seqKey(src), priority, payload)
+	return err
+}
+
+// Pull removes and returns the highest-priority payload in src, waiting
+// according to timeout: zero blocks forever (BZPOPMAX with a 0 timeout), a
+// positive duration blocks for at most that many seconds, rounded up to
+// the nearest whole second since BZPOPMAX's timeout is second-granularity,
+// and a negative duration returns immediately via ZPOPMAX if nothing is
+// queued.
+func (p *priorityProcessor) Pull(cnx redis.Conn, src string, timeout time.Duration) ([]byte, error) {
+	if timeout < 0 {
+		reply, err := redis.Values(cnx.Do("ZPOPMAX", src))
+		if err != nil {
+			return nil, err
+		}
+
+		if len(reply) == 0 {
+			return nil, nil
+		}
+
+		var payload []byte
+		if _, err := redis.Scan(reply, &payload); err != nil {
+			return nil, err
+		}
+
+		return payload, nil
+	}
+
+	reply, err := redis.Values(cnx.Do("BZPOPMAX", src, blockSeconds(timeout)))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(reply) == 0 {
+		return nil, nil
+	}
+
+	// BZPOPMAX replies with [key, member, score].
+	var (
+		key     string
+		payload []byte
+	)
+
+	if _, err := redis.Scan(reply, &key, &payload); err != nil {
+		return nil, err
+	}
+
+	return payload, nil
+}
+
+// PullTo removes the highest-priority payload in src and pushes it onto the
+// right of dest. See the priorityProcessor doc comment for the atomicity
+// caveat this carries relative to PullTo on a plain list.
+func (p *priorityProcessor) PullTo(cnx redis.Conn, src, dest string, timeout time.Duration) ([]byte, error) {
+	payload, err := p.Pull(cnx, src, timeout)
+	if err != nil || payload == nil {
+		return payload, err
+	}
+
+	if _, err := cnx.Do("RPUSH", dest, payload); err != nil {
+		return nil, err
+	}
+
+	return payload, nil
+}
+
+// Concat moves the highest-priority payload in src onto dest, returning
+// redis.ErrNil if src is empty, matching every other Processor's Concat in
+// this package. See the priorityProcessor doc comment for the atomicity
+// caveat this carries relative to Concat on a plain list.
+func (p *priorityProcessor) Concat(cnx redis.Conn, src, dest string) error {
+	payload, err := p.PullTo(cnx, src, dest, -1)
+	if err != nil {
+		return err
+	}
+
+	if payload == nil {
+		return redis.ErrNil
+	}
+
+	return nil
+}
@@ -0,0 +1,259 @@
+package queue
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// inflightSep separates the consumer name from the payload in an entry of
+// the in-flight ZSET, so the janitor can tell which per-consumer processing
+// list an expired lease needs to be reclaimed from.
+const inflightSep = "\x00"
+
+// inflightKey returns the key of the sorted set tracking src's in-flight
+// (claimed but not yet acknowledged) payloads, scored by lease expiry.
+func inflightKey(src string) string {
+	return src + ":inflight"
+}
+
+// processingKey returns the key of the per-consumer list a reliableProcessor
+// moves a payload into while it is being worked on.
+func processingKey(src, consumer string) string {
+	return src + ":processing:" + consumer
+}
+
+// reliableProcessor wraps another Processor (typically FIFO or LIFO) with
+// the "reliable queue" pattern: Pull moves the payload into a per-consumer
+// processing list with BRPOPLPUSH and records a lease deadline in a
+// companion ZSET, so a janitor can reclaim it if the consumer crashes
+// before acknowledging it with Ack or Nack. This narrows, but does not
+// close, the silent data-loss window that plain BRPOP has between
+// receiving a payload and finishing work on it: BRPOPLPUSH and the
+// lease-recording ZADD are two separate round trips (Redis has no atomic
+// blocking-pop-and-record-elsewhere primitive), so a crash in the gap
+// between them leaves the payload in the processing list with no in-flight
+// entry, where the janitor can never find it. Keep the two calls as close
+// together as possible and treat that gap as the residual failure mode of
+// this pattern.
+type reliableProcessor struct {
+	dest     Processor
+	consumer string
+	lease    time.Duration
+}
+
+// NewReliableProcessor builds a reliable Processor for the named consumer,
+// wrapping dest (typically FIFO or LIFO). Payloads pulled through it are
+// leased for the given duration; call Ack or Nack on the BaseQueue before
+// the lease expires, or the janitor started by NewReliableQueue will
+// reclaim the payload back onto the source list for redelivery.
+func NewReliableProcessor(dest Processor, consumer string, lease time.Duration) Processor {
+	return &reliableProcessor{dest: dest, consumer: consumer, lease: lease}
+}
+
+func (r *reliableProcessor) Push(cnx redis.Conn, src string, payload []byte) error {
+	return r.dest.Push(cnx, src, payload)
+}
+
+// Pull moves the next payload from src into this consumer's processing
+// list with BRPOPLPUSH, waiting according to timeout with the same
+// semantics as lifoProcessor.Pull, and records its lease expiry in src's
+// in-flight ZSET. These are two separate commands; see the reliableProcessor
+// doc comment for the crash window this leaves between them.
+func (r *reliableProcessor) Pull(cnx redis.Conn, src string, timeout time.Duration) ([]byte, error) {
+	dest := processingKey(src, r.consumer)
+
+	var (
+		payload []byte
+		err     error
+	)
+
+	if timeout < 0 {
+		payload, err = redis.Bytes(cnx.Do("RPOPLPUSH", src, dest))
+	} else {
+		payload, err = redis.Bytes(cnx.Do("BRPOPLPUSH", src, dest, blockSeconds(timeout)))
+	}
+
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.recordLease(cnx, src, payload); err != nil {
+		return nil, err
+	}
+
+	return payload, nil
+}
+
+func (r *reliableProcessor) PullTo(cnx redis.Conn, src, dest string, timeout time.Duration) ([]byte, error) {
+	return r.dest.PullTo(cnx, src, dest, timeout)
+}
+
+func (r *reliableProcessor) Concat(cnx redis.Conn, src, dest string) error {
+	return r.dest.Concat(cnx, src, dest)
+}
+
+// recordLease records payload's expiry in src's in-flight ZSET, tagged with this
+// processor's consumer name so the janitor knows which processing list to
+// reclaim it from.
+func (r *reliableProcessor) recordLease(cnx redis.Conn, src string, payload []byte) error {
+	member := r.consumer + inflightSep + string(payload)
+	score := strconv.FormatInt(time.Now().Add(r.lease).UnixNano()/int64(time.Millisecond), 10)
+
+	_, err := cnx.Do("ZADD", inflightKey(src), score, member)
+	return err
+}
+
+// Ack implements Acker. It removes payload from the in-flight ZSET and this
+// consumer's processing list, marking it as successfully handled.
+func (r *reliableProcessor) Ack(cnx redis.Conn, src string, payload []byte) error {
+	member := r.consumer + inflightSep + string(payload)
+
+	if _, err := cnx.Do("ZREM", inflightKey(src), member); err != nil {
+		return err
+	}
+
+	_, err := cnx.Do("LREM", processingKey(src, r.consumer), 1, payload)
+	return err
+}
+
+// Nack implements Acker. It removes payload from the in-flight ZSET and
+// this consumer's processing list, then pushes it back onto src so another
+// Pull can redeliver it immediately instead of waiting for its lease to
+// expire.
+func (r *reliableProcessor) Nack(cnx redis.Conn, src string, payload []byte) error {
+	if err := r.Ack(cnx, src, payload); err != nil {
+		return err
+	}
+
+	_, err := cnx.Do("RPUSH", src, payload)
+	return err
+}
+
+// reclaimScript atomically removes one occurrence of the expired payload
+// from the consumer's processing list by value, and only if that succeeds
+// does it drop the in-flight entry. Identifying the payload by value rather
+// than by position (e.g. a plain RPOPLPUSH) matters as soon as a consumer
+// has more than one payload in flight at once: positional reclaim can pop
+// a different, still-being-worked payload while leaving the actually
+// expired one stranded in the processing list with no in-flight record,
+// making it unreclaimable. Pushing the reclaimed payload back onto src is
+// left to reclaimExpired, since dest.Push (e.g. FIFO's LPUSH vs LIFO's
+// RPUSH) determines which end of src it actually belongs on. Returns 1 if
+// a payload was reclaimed, 0 if it was no longer present (e.g. already
+// acked).
+var reclaimScript = redis.NewScript(2, `
+local removed = redis.call('LREM', KEYS[1], 1, ARGV[1])
+if removed == 0 then
+	return 0
+end
+
+redis.call('ZREM', KEYS[2], ARGV[2])
+
+return 1
+`)
+
+// reclaimExpired scans src's in-flight ZSET for leases that expired at or
+// before now, and for each one runs reclaimScript to atomically remove its
+// payload from its owning consumer's processing list and drop its
+// in-flight entry, then pushes it back onto src through dest.Push so it
+// lands on the same end dest's own Push would use. It returns the number
+// of payloads reclaimed.
+func reclaimExpired(cnx redis.Conn, src string, dest Processor, now time.Time) (int, error) {
+	score := strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10)
+
+	members, err := redis.Strings(cnx.Do("ZRANGEBYSCORE", inflightKey(src), "-inf", score))
+	if err != nil {
+		return 0, err
+	}
+
+	reclaimed := 0
+
+	for _, member := range members {
+		consumer, payload, ok := splitInflightMember(member)
+		if !ok {
+			continue
+		}
+
+		n, err := redis.Int(reclaimScript.Do(cnx, processingKey(src, consumer), inflightKey(src), payload, member))
+		if err != nil {
+			return reclaimed, err
+		}
+
+		if n == 0 {
+			continue
+		}
+
+		if err := dest.Push(cnx, src, []byte(payload)); err != nil {
+			return reclaimed, err
+		}
+
+		reclaimed++
+	}
+
+	return reclaimed, nil
+}
+
+// splitInflightMember splits an in-flight ZSET member back into the
+// consumer name and payload it was tagged with by recordLease.
+func splitInflightMember(member string) (consumer, payload string, ok bool) {
+	idx := strings.Index(member, inflightSep)
+	if idx < 0 {
+		return "", "", false
+	}
+
+	return member[:idx], member[idx+len(inflightSep):], true
+}
+
+// startJanitor runs reclaimExpired against q every interval until the
+// process exits. It is started automatically by NewReliableQueue. If
+// onError is non-nil, it is called with every error reclaimExpired
+// returns, so a caller can observe (and alert on) the janitor going dark
+// instead of it failing silently forever.
+func startJanitor(q *BaseQueue, dest Processor, interval time.Duration, onError func(error)) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			cnx := q.factory.Pool().Get()
+			_, err := reclaimExpired(cnx, q.Source(), dest, time.Now())
+			cnx.Close()
+
+			if err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}()
+}
+
+// NewReliableQueue builds a BaseQueue using the reliable-queue pattern: Pull
+// leases payloads to the named consumer instead of removing them outright,
+// and a background janitor reclaims leases that expire without a matching
+// Ack/Nack, polling every janitorInterval. dest (typically FIFO or LIFO) is
+// the underlying Processor payloads are staged in. Call Ack or Nack on the
+// returned queue once a pulled payload has been handled. onError, if
+// non-nil, is called with every error the janitor encounters reclaiming
+// leases; pass nil to ignore them.
+func NewReliableQueue(pool *redis.Pool, source, consumer string, dest Processor, lease, janitorInterval time.Duration, onError func(error)) *BaseQueue {
+	return NewReliableQueueFromFactory(&staticPool{pool: pool}, source, consumer, dest, lease, janitorInterval, onError)
+}
+
+// NewReliableQueueFromFactory behaves like NewReliableQueue, but obtains its
+// Redis connections through factory rather than a fixed *redis.Pool, so a
+// reliable queue - including one using LIFO as dest - can be built on top
+// of a topology-aware PoolFactory such as the one backing NewSentinelQueue.
+func NewReliableQueueFromFactory(factory PoolFactory, source, consumer string, dest Processor, lease, janitorInterval time.Duration, onError func(error)) *BaseQueue {
+	q := NewBaseQueueFromFactory(factory, source)
+	q.SetProcessor(NewReliableProcessor(dest, consumer, lease))
+
+	startJanitor(q, dest, janitorInterval, onError)
+
+	return q
+}
@@ -0,0 +1,197 @@
+package queue
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// SentinelConfig describes how to reach a Redis Sentinel constellation
+// guarding a single monitored master.
+type SentinelConfig struct {
+	// Addrs is the list of sentinel addresses (host:port) to query and
+	// subscribe to. At least one is required; supplying every sentinel in
+	// the constellation lets discovery survive individual sentinel
+	// outages.
+	Addrs []string
+
+	// MasterName is the name of the monitored master, as configured by the
+	// sentinels' `sentinel monitor` directive.
+	MasterName string
+
+	// MaxIdle and IdleTimeout mirror the matching redis.Pool fields and are
+	// applied to the pool rebuilt against each newly discovered master.
+	MaxIdle     int
+	IdleTimeout time.Duration
+}
+
+// NewSentinelQueue builds a BaseQueue whose connections are obtained from a
+// pool pointed at the master discovered through Sentinel. It subscribes to
+// Sentinel's `+switch-master` pub/sub channel and rebuilds the pool in the
+// background on failover, so any Push/Pull/PullTo call started after the
+// swap is issued against the new master. A call already in flight against
+// the demoted master when the swap happens is not retried; it simply fails.
+func NewSentinelQueue(cfg SentinelConfig, source string) (*BaseQueue, error) {
+	factory, err := newSentinelPoolFactory(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewBaseQueueFromFactory(factory, source), nil
+}
+
+// sentinelPoolFactory is a PoolFactory that discovers the current Redis
+// master through Sentinel and swaps in a freshly built pool whenever a
+// `+switch-master` notification arrives.
+type sentinelPoolFactory struct {
+	cfg SentinelConfig
+
+	mu   sync.RWMutex
+	pool *redis.Pool
+}
+
+func newSentinelPoolFactory(cfg SentinelConfig) (*sentinelPoolFactory, error) {
+	if len(cfg.Addrs) == 0 {
+		return nil, errors.New("queue: SentinelConfig requires at least one address")
+	}
+
+	if cfg.MasterName == "" {
+		return nil, errors.New("queue: SentinelConfig requires a MasterName")
+	}
+
+	f := &sentinelPoolFactory{cfg: cfg}
+
+	addr, err := f.discoverMaster()
+	if err != nil {
+		return nil, err
+	}
+
+	f.pool = f.newPool(addr)
+
+	go f.watch()
+
+	return f, nil
+}
+
+// Pool implements PoolFactory.
+func (f *sentinelPoolFactory) Pool() *redis.Pool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.pool
+}
+
+// discoverMaster asks each configured sentinel in turn for the current
+// master address, returning the first successful answer.
+func (f *sentinelPoolFactory) discoverMaster() (string, error) {
+	var lastErr error
+
+	for _, addr := range f.cfg.Addrs {
+		cnx, err := redis.Dial("tcp", addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		reply, err := redis.Strings(cnx.Do("SENTINEL", "get-master-addr-by-name", f.cfg.MasterName))
+		cnx.Close()
+
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if len(reply) != 2 {
+			lastErr = errors.New("queue: unexpected SENTINEL get-master-addr-by-name reply")
+			continue
+		}
+
+		return reply[0] + ":" + reply[1], nil
+	}
+
+	return "", lastErr
+}
+
+// newPool builds a *redis.Pool dialing directly to addr, reusing the
+// idle-connection knobs from SentinelConfig.
+func (f *sentinelPoolFactory) newPool(addr string) *redis.Pool {
+	return &redis.Pool{
+		MaxIdle:     f.cfg.MaxIdle,
+		IdleTimeout: f.cfg.IdleTimeout,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", addr)
+		},
+	}
+}
+
+// watch blocks on a Sentinel pub/sub subscription and rebuilds the pool
+// whenever a +switch-master notification names this factory's master. If
+// the subscription connection is lost, it falls back to polling
+// discoverMaster until a sentinel becomes reachable again.
+func (f *sentinelPoolFactory) watch() {
+	for {
+		addr, ok := f.subscribeOnce()
+		if ok {
+			f.swap(addr)
+			continue
+		}
+
+		time.Sleep(time.Second)
+
+		if addr, err := f.discoverMaster(); err == nil {
+			f.swap(addr)
+		}
+	}
+}
+
+// subscribeOnce opens a pub/sub connection to the first reachable sentinel
+// and blocks until a +switch-master message for this factory's master
+// arrives, returning the new master's address. ok is false if no sentinel
+// could be reached or the connection was lost first.
+func (f *sentinelPoolFactory) subscribeOnce() (addr string, ok bool) {
+	for _, a := range f.cfg.Addrs {
+		cnx, err := redis.Dial("tcp", a)
+		if err != nil {
+			continue
+		}
+
+		psc := redis.PubSubConn{Conn: cnx}
+		if err := psc.Subscribe("+switch-master"); err != nil {
+			cnx.Close()
+			continue
+		}
+
+		for {
+			switch v := psc.Receive().(type) {
+			case redis.Message:
+				// Payload is "<master name> <old ip> <old port> <new ip> <new port>".
+				fields := strings.Fields(string(v.Data))
+				if len(fields) == 5 && fields[0] == f.cfg.MasterName {
+					cnx.Close()
+					return fields[3] + ":" + fields[4], true
+				}
+			case error:
+				cnx.Close()
+				return "", false
+			}
+		}
+	}
+
+	return "", false
+}
+
+// swap installs a freshly dialed pool for addr and closes the one it
+// replaces.
+func (f *sentinelPoolFactory) swap(addr string) {
+	pool := f.newPool(addr)
+
+	f.mu.Lock()
+	old := f.pool
+	f.pool = pool
+	f.mu.Unlock()
+
+	old.Close()
+}
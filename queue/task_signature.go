@@ -0,0 +1,23 @@
+package queue
+
+import "time"
+
+// TaskSignature is a transport envelope for task-framework payloads built
+// on top of a BaseQueue's PushObject/PullObject: it carries a task's name,
+// arguments, retry count, and scheduled time, so callers building a worker
+// pool on redutil don't need to invent their own envelope format.
+type TaskSignature struct {
+	// Name identifies which task this signature invokes.
+	Name string `json:"name" msgpack:"name"`
+
+	// Args are the task's positional arguments.
+	Args []interface{} `json:"args" msgpack:"args"`
+
+	// RetryCount is how many times this task has already been attempted.
+	RetryCount int `json:"retry_count" msgpack:"retry_count"`
+
+	// ETA is when the task is eligible to run. redutil never reads it; it
+	// only takes effect if the caller also passes it as the time argument
+	// of BaseQueue.PushObjectAt against a delayed Processor.
+	ETA time.Time `json:"eta" msgpack:"eta"`
+}